@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	common_grpc "github.com/gitpod-io/gitpod/common-go/grpc"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// GatewayRegistration registers a grpc-gateway handler against an in-process connection to this
+// server's own gRPC services. Generated handlers such as v1.RegisterBillingServiceHandler already
+// have this signature, so they can be passed to WithGRPCGateway directly.
+type GatewayRegistration func(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error
+
+// dialInProcess dials this server's own gRPC endpoint, for use by a grpc-gateway mux that wants
+// to reach the services registered on Server.GRPC() without going through the network stack
+// twice. It must only be called after the gRPC listener has been bound, i.e. from a
+// GatewayRegistration passed to WithGRPCGateway.
+func (s *Server) dialInProcess(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if s.grpcListener == nil {
+		return nil, fmt.Errorf("cannot dial in-process gRPC server: it is not listening")
+	}
+
+	creds, err := s.inProcessDialCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("cannot build in-process gRPC client credentials: %w", err)
+	}
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+	}, opts...)
+
+	return grpc.DialContext(ctx, s.grpcListener.Addr().String(), dialOpts...)
+}
+
+// inProcessDialCredentials returns the transport credentials dialInProcess should dial this
+// server's own gRPC listener with: insecure.NewCredentials() when the server isn't configured for
+// TLS, or mutual TLS built from the same CA/cert/key initializeGRPC uses to set up the server's
+// grpc.Creds, when it is. Without this, a TLS-enabled server using WithGRPCGateway would have
+// dialInProcess always attempt a plaintext connection and fail the handshake on every gateway
+// request.
+func (s *Server) inProcessDialCredentials() (credentials.TransportCredentials, error) {
+	if s.options.config == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	cfg := s.options.config.Services.GRPC
+	if cfg == nil || cfg.TLS == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig, err := common_grpc.ClientAuthTLSConfig(
+		cfg.TLS.CA, cfg.TLS.Cert, cfg.TLS.Key,
+		common_grpc.WithServerName(s.Name),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// mountGateways dials this server's own gRPC endpoint and runs every GatewayRegistration
+// registered via WithGRPCGateway against it, mounting the resulting grpc-gateway mux onto the
+// server's HTTP mux under /v1/. ListenAndServe calls this once the gRPC listener is bound and
+// before the HTTP server starts serving, so the gateway routes are already in place for the first
+// HTTP request - registering them any later would race the HTTP server's first requests.
+func (s *Server) mountGateways(ctx context.Context) error {
+	if len(s.options.gatewayRegistrations) == 0 {
+		return nil
+	}
+
+	conn, err := s.dialInProcess(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot dial in-process gRPC server for gateway registration: %w", err)
+	}
+
+	mux := runtime.NewServeMux()
+	for _, register := range s.options.gatewayRegistrations {
+		if err := register(ctx, mux, conn); err != nil {
+			return fmt.Errorf("cannot register gateway handler: %w", err)
+		}
+	}
+
+	s.httpMux.Handle("/v1/", mux)
+	return nil
+}
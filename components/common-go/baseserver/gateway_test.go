@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+func TestDialInProcess_FailsBeforeGRPCListenerIsBound(t *testing.T) {
+	s := &Server{}
+
+	if _, err := s.dialInProcess(context.Background()); err == nil {
+		t.Fatal("expected an error dialing in-process before the gRPC listener is bound, got nil")
+	}
+}
+
+func TestInProcessDialCredentials_InsecureWithoutTLS(t *testing.T) {
+	for name, s := range map[string]*Server{
+		"nil options.config": {options: &options{}},
+		"nil Services.GRPC":  {options: &options{config: &Configuration{}}},
+		"nil GRPC.TLS":       {options: &options{config: &Configuration{Services: ServicesConfiguration{GRPC: &ServerConfiguration{Address: ":1234"}}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			creds, err := s.inProcessDialCredentials()
+			if err != nil {
+				t.Fatalf("inProcessDialCredentials() error: %v", err)
+			}
+			if creds.Info().SecurityProtocol != insecure.NewCredentials().Info().SecurityProtocol {
+				t.Fatalf("got %s credentials, want insecure.NewCredentials() when the server isn't configured for TLS", creds.Info().SecurityProtocol)
+			}
+		})
+	}
+}
+
+func TestInProcessDialCredentials_TLSWhenServerIsConfiguredForTLS(t *testing.T) {
+	s := &Server{
+		Name: "test",
+		options: &options{
+			config: &Configuration{
+				Services: ServicesConfiguration{
+					GRPC: &ServerConfiguration{
+						Address: ":1234",
+						TLS:     &TLSConfiguration{CA: "ca.crt", Cert: "server.crt", Key: "server.key"},
+					},
+				},
+			},
+		},
+	}
+
+	creds, err := s.inProcessDialCredentials()
+	if err != nil {
+		t.Fatalf("inProcessDialCredentials() error: %v", err)
+	}
+	if creds.Info().SecurityProtocol != "tls" {
+		t.Fatalf("got %s credentials, want TLS when the server is configured for TLS - a TLS-enabled server using WithGRPCGateway would otherwise fail the in-process handshake on every gateway request", creds.Info().SecurityProtocol)
+	}
+}
+
+func TestMountGateways_RegistersRoutesOnHTTPMuxBeforeHTTPServing(t *testing.T) {
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to open a stand-in gRPC listener: %v", err)
+	}
+	defer grpcListener.Close()
+
+	var registered bool
+	register := func(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+		registered = true
+		mux.HandlePath(http.MethodGet, "/v1/ping", func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			w.Write([]byte("pong"))
+		})
+		return nil
+	}
+
+	s := &Server{
+		grpcListener: grpcListener,
+		httpMux:      http.NewServeMux(),
+		options:      &options{gatewayRegistrations: []GatewayRegistration{register}},
+	}
+
+	// mountGateways is what ListenAndServe calls between binding the gRPC listener and starting
+	// to serve HTTP - this is the one point dialInProcess/WithGRPCGateway can actually work.
+	if err := s.mountGateways(context.Background()); err != nil {
+		t.Fatalf("mountGateways failed: %v", err)
+	}
+	if !registered {
+		t.Fatal("GatewayRegistration was never invoked")
+	}
+
+	rec := httptest.NewRecorder()
+	s.httpMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/ping", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "pong" {
+		t.Fatalf("gateway route not reachable on httpMux: status=%d body=%q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMountGateways_NoopWithoutRegistrations(t *testing.T) {
+	s := &Server{
+		httpMux: http.NewServeMux(),
+		options: &options{},
+	}
+
+	// Must not try to dial (and thus must not fail) when no GatewayRegistration was configured -
+	// most Servers don't use WithGRPCGateway at all.
+	if err := s.mountGateways(context.Background()); err != nil {
+		t.Fatalf("mountGateways failed with no registrations: %v", err)
+	}
+}
@@ -0,0 +1,476 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// IdempotentRequest is implemented by request messages that carry an idempotency key, such as
+// UpdateInvoicesRequest. IdempotencyUnaryServerInterceptor only deduplicates calls to methods
+// whose request message implements it - a method opts into deduplication simply by having an
+// idempotency_key field.
+type IdempotentRequest interface {
+	proto.Message
+	GetIdempotencyKey() string
+}
+
+// IdempotencyStore persists (key -> response) pairs with an expiry, so a repeated call with the
+// same idempotency key within the TTL can return the cached response without re-executing the
+// RPC. typeName is the response message's full proto name, needed to reconstruct it on Get.
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) (typeName string, data []byte, ok bool, err error)
+	Put(ctx context.Context, key string, typeName string, data []byte, ttl time.Duration) error
+}
+
+// IdempotencyLocker is implemented by IdempotencyStores that can coordinate concurrent callers
+// across replicas, e.g. via Redis SETNX. IdempotencyUnaryServerInterceptor uses it in preference
+// to an in-process singleflight.Group when the configured store supports it: singleflight only
+// collapses concurrent calls within this process, so on a multi-replica deployment two calls with
+// the same key landing on two different pods would both miss store.Get and both invoke handler -
+// the exact double-invoicing singleflight looks like it prevents but doesn't.
+//
+// Lock is keyed by an opaque token rather than just the lock key, so Unlock and Renew can tell
+// "this call's lock" apart from a lock some other caller acquired after this one expired - without
+// a token, a slow holder whose lock expired mid-call would Unlock (or Renew) a different caller's
+// lock on the same key.
+type IdempotencyLocker interface {
+	// Lock attempts to acquire the lock for key, valid for up to ttl so a crashed holder doesn't
+	// wedge the key forever. It returns acquired=false, "", nil if another caller already holds
+	// it, and otherwise a token that must be passed to Renew/Unlock to prove ownership.
+	Lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error)
+	// Renew extends a held lock's expiry to ttl from now, provided token still matches the
+	// current holder. It returns ok=false if the lock expired and was taken by someone else (or
+	// released) in the meantime, in which case the caller no longer holds it.
+	Renew(ctx context.Context, key, token string, ttl time.Duration) (ok bool, err error)
+	// Unlock releases a lock obtained from Lock, provided token still matches the current holder.
+	// It must be safe to call even after the lock has already expired or been taken over by
+	// another caller - in that case it must leave the new holder's lock alone.
+	Unlock(ctx context.Context, key, token string) error
+}
+
+// IdempotencyUnaryServerInterceptor returns a grpc.UnaryServerInterceptor that deduplicates
+// calls whose request implements IdempotentRequest and carries a non-empty idempotency key:
+// a repeated call with the same key within ttl returns the cached response instead of
+// re-invoking handler. Concurrent calls with the same key are serialized so only one actually
+// invokes handler - via store.(IdempotencyLocker) when store supports it (safe across replicas),
+// falling back to an in-process singleflight.Group otherwise (e.g. InMemoryIdempotencyStore in
+// tests). This is essential for RPCs like UpdateInvoices that are called from cron/retry loops
+// against Stripe, where double-invoicing is unacceptable.
+func IdempotencyUnaryServerInterceptor(store IdempotencyStore, ttl time.Duration) grpc.UnaryServerInterceptor {
+	locker, distributed := store.(IdempotencyLocker)
+	var group singleflight.Group
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		idempotent, ok := req.(IdempotentRequest)
+		if !ok || idempotent.GetIdempotencyKey() == "" {
+			return handler(ctx, req)
+		}
+
+		key := info.FullMethod + ":" + idempotent.GetIdempotencyKey()
+
+		if resp, hit, err := lookupIdempotentResponse(ctx, store, key); err != nil {
+			return nil, err
+		} else if hit {
+			return resp, nil
+		}
+
+		if !distributed {
+			resp, err, _ := group.Do(key, func() (interface{}, error) {
+				return invokeAndCacheIdempotentResponse(ctx, store, key, ttl, info.FullMethod, req, handler)
+			})
+			return resp, err
+		}
+
+		return invokeWithDistributedLock(ctx, store, locker, key, ttl, info.FullMethod, req, handler)
+	}
+}
+
+// distributedLockTTL bounds how long a distributed lock may be held without being renewed, so a
+// replica that crashes mid-call doesn't wedge the key forever. It is intentionally independent of
+// the caller-supplied cache ttl (which governs how long a *response* stays fresh, not how long a
+// single call is allowed to run): reusing ttl as the lock duration would let a handler slower than
+// the cache TTL have its lock expire mid-flight and get invoked a second time by a replica that
+// raced in behind it. renewDistributedLockWhileHandlerRuns keeps the lock alive for handlers that
+// run longer than this.
+const distributedLockTTL = 30 * time.Second
+
+// distributedLockRenewInterval is how often the held lock's expiry is pushed out while handler
+// runs. It must be comfortably shorter than distributedLockTTL so a missed tick (GC pause, slow
+// Redis round trip) doesn't let the lock lapse before the next renewal fires. It's a var rather
+// than a const solely so tests can shrink it instead of waiting out the real interval.
+var distributedLockRenewInterval = distributedLockTTL / 3
+
+// invokeWithDistributedLock acquires locker's lock for key before invoking handler, so that a
+// concurrent call with the same key on another replica waits for the cached response instead of
+// also invoking handler. Callers that lose the race poll store until the lock holder's response
+// lands in the cache or ctx is done.
+//
+// Each iteration checks the cache before attempting to acquire the lock, not after: the lock
+// holder always writes its response to store before releasing the lock (see
+// invokeAndCacheIdempotentResponse below), so a caller that wakes up once the lock has been
+// released is guaranteed to see the cached response here rather than winning the now-free lock
+// and redundantly re-invoking handler.
+func invokeWithDistributedLock(ctx context.Context, store IdempotencyStore, locker IdempotencyLocker, key string, ttl time.Duration, method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	const pollInterval = 50 * time.Millisecond
+
+	for {
+		if resp, hit, err := lookupIdempotentResponse(ctx, store, key); err != nil {
+			return nil, err
+		} else if hit {
+			return resp, nil
+		}
+
+		token, acquired, err := locker.Lock(ctx, key, distributedLockTTL)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "idempotency lock failed: %v", err)
+		}
+		if acquired {
+			handlerCtx, cancelHandler := context.WithCancel(ctx)
+			stopRenew := make(chan struct{})
+			renewDone := make(chan struct{})
+			go renewDistributedLockWhileHandlerRuns(locker, key, token, method, distributedLockRenewInterval, cancelHandler, stopRenew, renewDone)
+
+			resp, err := invokeAndCacheIdempotentResponse(handlerCtx, store, key, ttl, method, req, handler)
+
+			close(stopRenew)
+			<-renewDone
+			cancelHandler()
+
+			if unlockErr := locker.Unlock(context.Background(), key, token); unlockErr != nil {
+				logrus.WithError(unlockErr).WithField("method", method).Warn("failed to release idempotency lock")
+			}
+			if err == nil && handlerCtx.Err() != nil {
+				return nil, status.Errorf(codes.Aborted, "idempotency lock for %q was lost mid-call; aborting to avoid a concurrent duplicate invocation", key)
+			}
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, status.Errorf(codes.DeadlineExceeded, "timed out waiting for concurrent idempotent call to %q to complete", key)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// renewDistributedLockWhileHandlerRuns periodically extends the distributed lock identified by
+// key/token until stop is closed (handler returned) or a renewal finds the lock was lost - e.g.
+// because a renewal was delayed past distributedLockTTL and another replica took over the key. In
+// the latter case it calls cancelHandler so the in-flight handler(ctx, req) call still running in
+// invokeAndCacheIdempotentResponse is aborted instead of being left to complete: once another
+// replica's Lock has succeeded, letting this replica's handler run to completion would commit a
+// second, redundant invocation (e.g. a second Stripe call) for the same idempotency key.
+// It signals its own exit on done so invokeWithDistributedLock can wait for the last renewal
+// attempt to finish before releasing the lock. interval is distributedLockRenewInterval in
+// production; tests pass a shorter one so they don't have to wait out the real constant.
+func renewDistributedLockWhileHandlerRuns(locker IdempotencyLocker, key, token, method string, interval time.Duration, cancelHandler context.CancelFunc, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ok, err := locker.Renew(context.Background(), key, token, distributedLockTTL)
+			if err != nil {
+				logrus.WithError(err).WithField("method", method).Warn("failed to renew idempotency lock")
+				continue
+			}
+			if !ok {
+				logrus.WithField("method", method).WithField("key", key).Warn("idempotency lock was lost mid-call; cancelling in-flight handler to avoid a concurrent duplicate invocation")
+				cancelHandler()
+				return
+			}
+		}
+	}
+}
+
+// lookupIdempotentResponse returns the cached response for key, if any.
+func lookupIdempotentResponse(ctx context.Context, store IdempotencyStore, key string) (interface{}, bool, error) {
+	typeName, data, hit, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, false, status.Errorf(codes.Internal, "idempotency store lookup failed: %v", err)
+	}
+	if !hit {
+		return nil, false, nil
+	}
+
+	resp, err := decodeIdempotentResponse(typeName, data)
+	if err != nil {
+		return nil, false, status.Errorf(codes.Internal, "cannot decode cached idempotent response: %v", err)
+	}
+	return resp, true, nil
+}
+
+// invokeAndCacheIdempotentResponse invokes handler and, on success, caches the response under key
+// so a subsequent call with the same key can be served from store instead of re-invoking handler.
+func invokeAndCacheIdempotentResponse(ctx context.Context, store IdempotencyStore, key string, ttl time.Duration, method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg, ok := resp.(proto.Message); ok {
+		if data, err := proto.Marshal(msg); err == nil {
+			typeName := string(msg.ProtoReflect().Descriptor().FullName())
+			if err := store.Put(ctx, key, typeName, data, ttl); err != nil {
+				logrus.WithError(err).WithField("method", method).Warn("failed to cache idempotent response")
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func decodeIdempotentResponse(typeName string, data []byte) (proto.Message, error) {
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(typeName))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := msgType.New().Interface()
+	if err := proto.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// IdempotentSession is implemented by messages streamed to a method guarded by
+// IdempotentSessionStreamInterceptor, such as BilledSession. It mirrors IdempotentRequest for the
+// client-streaming case, where there's no single request message to key the whole call off of -
+// only the individual sessions flowing through the stream.
+type IdempotentSession interface {
+	proto.Message
+	GetSessionId() string
+}
+
+// IdempotentSessionStreamInterceptor returns a grpc.StreamServerInterceptor that deduplicates
+// messages on a client-streaming RPC by session_id: a message whose session_id was already seen
+// within ttl is dropped before it reaches handler, instead of being committed to the invoice
+// backend a second time. This complements IdempotencyUnaryServerInterceptor's request-level
+// idempotency_key, which StreamUpdateInvoices has no use for since it takes a stream of
+// BilledSessions rather than a single request.
+//
+// Unlike the unary path, a session is marked seen before handler has durably committed it (there
+// is no per-message ack to hang the Put off of), so a handler crash between RecvMsg and commit
+// can cause a session to be skipped on retry rather than double-committed. That tradeoff is
+// intentional: for billing, silently dropping a retry is preferable to double-invoicing Stripe.
+func IdempotentSessionStreamInterceptor(store IdempotencyStore, ttl time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &dedupingServerStream{ServerStream: ss, store: store, ttl: ttl})
+	}
+}
+
+type dedupingServerStream struct {
+	grpc.ServerStream
+
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+func (s *dedupingServerStream) RecvMsg(m interface{}) error {
+	for {
+		if err := s.ServerStream.RecvMsg(m); err != nil {
+			return err
+		}
+
+		session, ok := m.(IdempotentSession)
+		if !ok || session.GetSessionId() == "" {
+			return nil
+		}
+
+		ctx := s.Context()
+		key := "session:" + session.GetSessionId()
+
+		_, _, hit, err := s.store.Get(ctx, key)
+		if err != nil {
+			return status.Errorf(codes.Internal, "idempotency store lookup failed: %v", err)
+		}
+		if hit {
+			// Already processed in a previous call with this session_id; skip it without
+			// handing it to handler and move on to the next message in the stream.
+			continue
+		}
+
+		if err := s.store.Put(ctx, key, "", nil, s.ttl); err != nil {
+			logrus.WithError(err).WithField("session_id", session.GetSessionId()).Warn("failed to record session dedup marker")
+		}
+		return nil
+	}
+}
+
+// InMemoryIdempotencyStore is an IdempotencyStore backed by a process-local map. It's suitable
+// for single-replica deployments and tests; use RedisIdempotencyStore when multiple replicas
+// need to share the idempotency cache.
+type InMemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]inMemoryEntry
+}
+
+type inMemoryEntry struct {
+	typeName string
+	data     []byte
+	expiry   time.Time
+}
+
+func NewInMemoryIdempotencyStore() *InMemoryIdempotencyStore {
+	return &InMemoryIdempotencyStore{entries: make(map[string]inMemoryEntry)}
+}
+
+func (s *InMemoryIdempotencyStore) Get(_ context.Context, key string) (string, []byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expiry) {
+		delete(s.entries, key)
+		return "", nil, false, nil
+	}
+	return entry.typeName, entry.data, true, nil
+}
+
+func (s *InMemoryIdempotencyStore) Put(_ context.Context, key, typeName string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = inMemoryEntry{typeName: typeName, data: data, expiry: time.Now().Add(ttl)}
+	return nil
+}
+
+// RedisIdempotencyStore is an IdempotencyStore backed by Redis, for deployments that run
+// multiple replicas of a server and need the idempotency cache shared between them. It also
+// implements IdempotencyLocker, so IdempotencyUnaryServerInterceptor serializes concurrent
+// callers across replicas via Redis SETNX rather than only within this process.
+type RedisIdempotencyStore struct {
+	client *redis.Client
+}
+
+var _ IdempotencyLocker = (*RedisIdempotencyStore)(nil)
+
+func NewRedisIdempotencyStore(client *redis.Client) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{client: client}
+}
+
+type redisIdempotencyEntry struct {
+	TypeName string `json:"type_name"`
+	Data     []byte `json:"data"`
+}
+
+func (s *RedisIdempotencyStore) Get(ctx context.Context, key string) (string, []byte, bool, error) {
+	raw, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	var entry redisIdempotencyEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return "", nil, false, err
+	}
+	return entry.TypeName, entry.Data, true, nil
+}
+
+func (s *RedisIdempotencyStore) Put(ctx context.Context, key, typeName string, data []byte, ttl time.Duration) error {
+	raw, err := json.Marshal(redisIdempotencyEntry{TypeName: typeName, Data: data})
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, key, raw, ttl).Err()
+}
+
+// renewLockScript extends the lock's TTL only if it is still held by token, so a caller whose
+// lock already expired and was taken over by another replica can't clobber that replica's lock by
+// renewing on top of it.
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript deletes the lock only if it is still held by token, for the same reason
+// renewLockScript checks it: Unlock must never delete a different caller's lock just because this
+// caller's own lock already expired.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lock implements IdempotencyLocker using Redis SETNX with a random per-call token as the value,
+// which only one caller can win across all replicas sharing this client's Redis instance. The
+// token lets Renew and Unlock tell this call's lock apart from one a different caller acquired
+// after this one expired.
+func (s *RedisIdempotencyStore) Lock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token, err := randomLockToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	acquired, err := s.client.SetNX(ctx, redisLockKey(key), token, ttl).Result()
+	if err != nil {
+		return "", false, err
+	}
+	if !acquired {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+// Renew implements IdempotencyLocker by extending the lock's TTL via renewLockScript, which only
+// takes effect if token still matches the current holder.
+func (s *RedisIdempotencyStore) Renew(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	renewed, err := renewLockScript.Run(ctx, s.client, []string{redisLockKey(key)}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// Unlock implements IdempotencyLocker by deleting the SETNX key Lock created via
+// releaseLockScript, which only deletes it if token still matches the current holder - so it's
+// safe to call even after the lock already expired and was taken over by another caller, or was
+// already released: in both cases it leaves the key alone instead of deleting someone else's lock.
+func (s *RedisIdempotencyStore) Unlock(ctx context.Context, key, token string) error {
+	_, err := releaseLockScript.Run(ctx, s.client, []string{redisLockKey(key)}, token).Result()
+	return err
+}
+
+func redisLockKey(key string) string {
+	return "lock:" + key
+}
+
+// randomLockToken generates an opaque value to identify the holder of a distributed lock.
+func randomLockToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("cannot generate idempotency lock token: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeIdempotentRequest implements IdempotentRequest by embedding a real proto.Message
+// (emptypb.Empty) for Reset/String/ProtoReflect and adding the idempotency key on top.
+type fakeIdempotentRequest struct {
+	*emptypb.Empty
+	key string
+}
+
+func (f *fakeIdempotentRequest) GetIdempotencyKey() string { return f.key }
+
+// fakeLock records a fakeLockingStore lock's owning token and when it expires, so tests can
+// simulate a lock lapsing mid-call the way a real Redis TTL would.
+type fakeLock struct {
+	token  string
+	expiry time.Time
+}
+
+// fakeLockingStore is an in-memory IdempotencyStore that also implements IdempotencyLocker,
+// standing in for RedisIdempotencyStore in tests that don't want a real Redis. Unlike a plain
+// bool-map stand-in, it honors ttl expiry and per-call tokens, so it can actually exercise the
+// lock-stealing and mid-call-expiry bugs RedisIdempotencyStore must avoid.
+type fakeLockingStore struct {
+	InMemoryIdempotencyStore
+
+	mu     sync.Mutex
+	locks  map[string]fakeLock
+	tokens int
+}
+
+func newFakeLockingStore() *fakeLockingStore {
+	return &fakeLockingStore{
+		InMemoryIdempotencyStore: *NewInMemoryIdempotencyStore(),
+		locks:                    make(map[string]fakeLock),
+	}
+}
+
+func (s *fakeLockingStore) Lock(_ context.Context, key string, ttl time.Duration) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.locks[key]; ok && time.Now().Before(l.expiry) {
+		return "", false, nil
+	}
+
+	s.tokens++
+	token := fmt.Sprintf("token-%d", s.tokens)
+	s.locks[key] = fakeLock{token: token, expiry: time.Now().Add(ttl)}
+	return token, true, nil
+}
+
+func (s *fakeLockingStore) Renew(_ context.Context, key, token string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.locks[key]
+	if !ok || l.token != token {
+		return false, nil
+	}
+	s.locks[key] = fakeLock{token: token, expiry: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (s *fakeLockingStore) Unlock(_ context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.locks[key]; ok && l.token == token {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+var _ IdempotencyLocker = (*fakeLockingStore)(nil)
+
+func TestIdempotencyUnaryServerInterceptor_CachesResponse(t *testing.T) {
+	store := NewInMemoryIdempotencyStore()
+	interceptor := IdempotencyUnaryServerInterceptor(store, time.Minute)
+
+	var calls int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return &emptypb.Empty{}, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/usage.v1.BillingService/UpdateInvoices"}
+	req := &fakeIdempotentRequest{Empty: &emptypb.Empty{}, key: "key-1"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := interceptor(context.Background(), req, info, handler); err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler invoked %d times, want 1 (repeat calls should hit the cache)", got)
+	}
+}
+
+func TestIdempotencyUnaryServerInterceptor_SkipsRequestsWithoutKey(t *testing.T) {
+	store := NewInMemoryIdempotencyStore()
+	interceptor := IdempotencyUnaryServerInterceptor(store, time.Minute)
+
+	var calls int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return &emptypb.Empty{}, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/usage.v1.BillingService/UpdateInvoices"}
+	req := &fakeIdempotentRequest{Empty: &emptypb.Empty{}, key: ""}
+
+	if _, err := interceptor(context.Background(), req, info, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := interceptor(context.Background(), req, info, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("handler invoked %d times, want 2 (no key means no dedup)", got)
+	}
+}
+
+func TestIdempotencyUnaryServerInterceptor_DistributedLockSerializesConcurrentCalls(t *testing.T) {
+	store := newFakeLockingStore()
+	interceptor := IdempotencyUnaryServerInterceptor(store, time.Minute)
+
+	var calls int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		// Simulate two replicas racing to invoice the same key: give the loser of the Lock
+		// race plenty of time to poll and observe the winner's cached response.
+		time.Sleep(20 * time.Millisecond)
+		return &emptypb.Empty{}, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/usage.v1.BillingService/UpdateInvoices"}
+	req := &fakeIdempotentRequest{Empty: &emptypb.Empty{}, key: "concurrent-key"}
+
+	const concurrency = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := interceptor(context.Background(), req, info, handler)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler invoked %d times across %d concurrent callers, want 1 - this is the double-invoicing bug the distributed lock exists to prevent", got, concurrency)
+	}
+}
+
+func TestRenewDistributedLockWhileHandlerRuns_SurvivesPastOriginalExpiry(t *testing.T) {
+	store := newFakeLockingStore()
+
+	// A lock with a TTL far shorter than the handler below would take to run on its own -
+	// without renewal, it would lapse mid-call and let a second caller in.
+	const lockTTL = 30 * time.Millisecond
+	token, acquired, err := store.Lock(context.Background(), "key", lockTTL)
+	if err != nil || !acquired {
+		t.Fatalf("Lock() = (%q, %v, %v), want acquired", token, acquired, err)
+	}
+
+	handlerCtx, cancelHandler := context.WithCancel(context.Background())
+	defer cancelHandler()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go renewDistributedLockWhileHandlerRuns(store, "key", token, "method", 10*time.Millisecond, cancelHandler, stop, done)
+
+	// Longer than lockTTL: a second caller racing in here must still see the lock held, proving
+	// the background renewal (not just the original Lock) is what's keeping it alive.
+	time.Sleep(100 * time.Millisecond)
+	if _, acquired, err := store.Lock(context.Background(), "key", lockTTL); err != nil || acquired {
+		t.Fatalf("a second Lock() call succeeded while the first holder's lock was being renewed")
+	}
+	if handlerCtx.Err() != nil {
+		t.Errorf("renewDistributedLockWhileHandlerRuns cancelled the handler although every renewal succeeded")
+	}
+
+	close(stop)
+	<-done
+
+	if err := store.Unlock(context.Background(), "key", token); err != nil {
+		t.Fatalf("Unlock() error: %v", err)
+	}
+}
+
+// flakyRenewLockingStore wraps a fakeLockingStore but makes Renew report the lock lost (ok=false)
+// from the renewalsUntilLost'th call onward, simulating a renewal that missed its deadline (Redis
+// blip, GC pause) past distributedLockTTL - without actually waiting out a real TTL.
+type flakyRenewLockingStore struct {
+	*fakeLockingStore
+
+	renewalsUntilLost int32
+	renewCount        int32
+}
+
+func (s *flakyRenewLockingStore) Renew(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	if atomic.AddInt32(&s.renewCount, 1) > s.renewalsUntilLost {
+		return false, nil
+	}
+	return s.fakeLockingStore.Renew(ctx, key, token, ttl)
+}
+
+func TestRenewDistributedLockWhileHandlerRuns_CancelsHandlerWhenLockLost(t *testing.T) {
+	store := &flakyRenewLockingStore{fakeLockingStore: newFakeLockingStore(), renewalsUntilLost: 1}
+
+	token, acquired, err := store.Lock(context.Background(), "key", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Lock() = (%q, %v, %v), want acquired", token, acquired, err)
+	}
+
+	handlerCtx, cancelHandler := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go renewDistributedLockWhileHandlerRuns(store, "key", token, "method", 5*time.Millisecond, cancelHandler, stop, done)
+
+	select {
+	case <-handlerCtx.Done():
+		// The handler's context must be cancelled once a renewal reports the lock lost, so the
+		// in-flight call aborts instead of finishing and writing a second, redundant response
+		// alongside whatever replica now holds the lock.
+	case <-time.After(time.Second):
+		t.Fatal("handler context was never cancelled after the lock was reported lost")
+	}
+
+	close(stop)
+	<-done
+}
+
+func TestIdempotencyUnaryServerInterceptor_AbortsHandlerWhenLockLostMidCall(t *testing.T) {
+	original := distributedLockRenewInterval
+	distributedLockRenewInterval = 5 * time.Millisecond
+	defer func() { distributedLockRenewInterval = original }()
+
+	store := &flakyRenewLockingStore{fakeLockingStore: newFakeLockingStore(), renewalsUntilLost: 0}
+	interceptor := IdempotencyUnaryServerInterceptor(store, time.Minute)
+
+	handlerStarted := make(chan struct{})
+	handlerSawCancel := make(chan bool, 1)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		close(handlerStarted)
+		select {
+		case <-ctx.Done():
+			handlerSawCancel <- true
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			handlerSawCancel <- false
+			return &emptypb.Empty{}, nil
+		}
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/usage.v1.BillingService/UpdateInvoices"}
+	req := &fakeIdempotentRequest{Empty: &emptypb.Empty{}, key: "lost-lock-key"}
+
+	_, err := interceptor(context.Background(), req, info, handler)
+	<-handlerStarted
+
+	if err == nil {
+		t.Fatal("interceptor returned no error, want an error once the lock was lost mid-call")
+	}
+	if !<-handlerSawCancel {
+		t.Fatal("handler's context was never cancelled after the lock was lost mid-call")
+	}
+}
+
+func TestFakeLockingStore_UnlockWithStaleTokenDoesNotStealNewHolderLock(t *testing.T) {
+	store := newFakeLockingStore()
+
+	firstToken, acquired, err := store.Lock(context.Background(), "key", time.Millisecond)
+	if err != nil || !acquired {
+		t.Fatalf("first Lock() = (%q, %v, %v), want acquired", firstToken, acquired, err)
+	}
+
+	// Let the first holder's lock expire, then have a second caller acquire it.
+	time.Sleep(5 * time.Millisecond)
+	secondToken, acquired, err := store.Lock(context.Background(), "key", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("second Lock() = (%q, %v, %v), want acquired", secondToken, acquired, err)
+	}
+
+	// The first holder, unaware its lock already lapsed, releases using its stale token. This
+	// must not delete the second holder's still-valid lock.
+	if err := store.Unlock(context.Background(), "key", firstToken); err != nil {
+		t.Fatalf("Unlock() error: %v", err)
+	}
+
+	if _, acquired, err := store.Lock(context.Background(), "key", time.Minute); err != nil || acquired {
+		t.Fatalf("a third Lock() call succeeded after a stale Unlock, want the second holder's lock to still be held")
+	}
+}
+
+// fakeSession implements IdempotentSession by embedding a real proto.Message for
+// Reset/String/ProtoReflect and adding session_id on top.
+type fakeSession struct {
+	*emptypb.Empty
+	sessionID string
+}
+
+func (f *fakeSession) GetSessionId() string { return f.sessionID }
+
+// fakeServerStream is a minimal grpc.ServerStream that replays a fixed sequence of *fakeSessions
+// to RecvMsg, then returns io.EOF.
+type fakeServerStream struct {
+	grpc.ServerStream
+
+	ctx    context.Context
+	recvs  []*fakeSession
+	cursor int
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if s.cursor >= len(s.recvs) {
+		return io.EOF
+	}
+	*m.(*fakeSession) = *s.recvs[s.cursor]
+	s.cursor++
+	return nil
+}
+
+func TestIdempotentSessionStreamInterceptor_DropsDuplicateSessions(t *testing.T) {
+	store := NewInMemoryIdempotencyStore()
+	stream := &fakeServerStream{
+		ctx: context.Background(),
+		recvs: []*fakeSession{
+			{Empty: &emptypb.Empty{}, sessionID: "a"},
+			{Empty: &emptypb.Empty{}, sessionID: "b"},
+			{Empty: &emptypb.Empty{}, sessionID: "a"}, // duplicate of the first - must be skipped
+		},
+	}
+	deduping := &dedupingServerStream{ServerStream: stream, store: store, ttl: time.Minute}
+
+	var got []string
+	for {
+		m := &fakeSession{Empty: &emptypb.Empty{}}
+		err := deduping.RecvMsg(m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, m.GetSessionId())
+	}
+
+	want := []string{"a", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got sessions %v, want %v (the repeated %q must be dropped)", got, want, "a")
+	}
+}
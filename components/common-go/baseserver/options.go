@@ -0,0 +1,244 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/heptiolabs/healthcheck"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Option configures the Server returned by New.
+type Option func(*options) error
+
+type options struct {
+	config *Configuration
+
+	logger          *logrus.Entry
+	metricsRegistry *prometheus.Registry
+	healthHandler   healthcheck.Handler
+	grpcHealthCheck grpc_health_v1.HealthServer
+	closeTimeout    time.Duration
+	version         string
+
+	tracingExporter sdktrace.SpanExporter
+	traceSampler    sdktrace.Sampler
+
+	idempotencyStore IdempotencyStore
+	idempotencyTTL   time.Duration
+
+	maxRecvMsgSize int
+
+	gatewayRegistrations []GatewayRegistration
+	streamInterceptors   []grpc.StreamServerInterceptor
+}
+
+// Configuration configures the services a Server exposes.
+type Configuration struct {
+	Services ServicesConfiguration
+}
+
+// ServicesConfiguration configures the debug, HTTP and gRPC listeners of a Server. Any of these
+// may be nil, in which case the corresponding service is not started.
+type ServicesConfiguration struct {
+	Debug *ServerConfiguration
+	HTTP  *ServerConfiguration
+	GRPC  *ServerConfiguration
+}
+
+// ServerConfiguration configures a single listener.
+type ServerConfiguration struct {
+	Address string
+	TLS     *TLSConfiguration
+}
+
+// GetAddress returns cfg.Address, or "" if cfg is nil.
+func (cfg *ServerConfiguration) GetAddress() string {
+	if cfg == nil {
+		return ""
+	}
+	return cfg.Address
+}
+
+// TLSConfiguration configures mutual TLS for a listener.
+type TLSConfiguration struct {
+	CA   string
+	Cert string
+	Key  string
+}
+
+func defaultOptions() *options {
+	return &options{
+		config: &Configuration{
+			Services: ServicesConfiguration{
+				Debug: &ServerConfiguration{Address: ":9500"},
+			},
+		},
+		logger:          logrus.NewEntry(logrus.StandardLogger()),
+		metricsRegistry: prometheus.NewRegistry(),
+		healthHandler:   healthcheck.NewHandler(),
+		grpcHealthCheck: health.NewServer(),
+		closeTimeout:    5 * time.Second,
+	}
+}
+
+func evaluateOptions(opts *options, additional ...Option) (*options, error) {
+	for _, o := range additional {
+		if err := o(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// WithHTTPPort configures the server to serve HTTP on the given port.
+func WithHTTPPort(port int) Option {
+	return func(o *options) error {
+		if port < 0 {
+			return fmt.Errorf("HTTP port must not be negative: %d", port)
+		}
+		o.config.Services.HTTP = &ServerConfiguration{Address: fmt.Sprintf(":%d", port)}
+		return nil
+	}
+}
+
+// WithGRPCPort configures the server to serve gRPC on the given port.
+func WithGRPCPort(port int) Option {
+	return func(o *options) error {
+		if port < 0 {
+			return fmt.Errorf("gRPC port must not be negative: %d", port)
+		}
+		o.config.Services.GRPC = &ServerConfiguration{Address: fmt.Sprintf(":%d", port)}
+		return nil
+	}
+}
+
+// WithDebugPort configures the address the debug server listens on.
+func WithDebugPort(port int) Option {
+	return func(o *options) error {
+		if port < 0 {
+			return fmt.Errorf("debug port must not be negative: %d", port)
+		}
+		o.config.Services.Debug = &ServerConfiguration{Address: fmt.Sprintf(":%d", port)}
+		return nil
+	}
+}
+
+// WithCloseTimeout configures how long Close waits for in-flight requests to finish.
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.closeTimeout = timeout
+		return nil
+	}
+}
+
+// WithLogger overrides the logger the server reports through Logger().
+func WithLogger(logger *logrus.Entry) Option {
+	return func(o *options) error {
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithVersion sets the version reported by the server, e.g. on /live.
+func WithVersion(version string) Option {
+	return func(o *options) error {
+		o.version = version
+		return nil
+	}
+}
+
+// WithMetricsRegistry overrides the Prometheus registry the server registers its metrics on.
+func WithMetricsRegistry(registry *prometheus.Registry) Option {
+	return func(o *options) error {
+		if registry == nil {
+			return fmt.Errorf("metrics registry must not be nil")
+		}
+		o.metricsRegistry = registry
+		return nil
+	}
+}
+
+// WithTracing enables OpenTelemetry tracing on the gRPC and HTTP servers, exporting completed
+// spans through exporter (e.g. an OTLP/gRPC or Jaeger exporter).
+func WithTracing(exporter sdktrace.SpanExporter) Option {
+	return func(o *options) error {
+		if exporter == nil {
+			return fmt.Errorf("tracing exporter must not be nil")
+		}
+		o.tracingExporter = exporter
+		return nil
+	}
+}
+
+// WithTraceSampler overrides the default sampler (sdktrace.ParentBased(sdktrace.AlwaysSample()))
+// used when tracing is enabled via WithTracing.
+func WithTraceSampler(sampler sdktrace.Sampler) Option {
+	return func(o *options) error {
+		if sampler == nil {
+			return fmt.Errorf("trace sampler must not be nil")
+		}
+		o.traceSampler = sampler
+		return nil
+	}
+}
+
+// WithIdempotency enables IdempotencyUnaryServerInterceptor, caching responses to
+// IdempotentRequest-implementing methods in store for ttl.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(o *options) error {
+		if store == nil {
+			return fmt.Errorf("idempotency store must not be nil")
+		}
+		o.idempotencyStore = store
+		o.idempotencyTTL = ttl
+		return nil
+	}
+}
+
+// WithMaxRecvMsgSize overrides gRPC's default max receive message size, for services that take
+// client-streaming RPCs with individually large messages.
+func WithMaxRecvMsgSize(bytes int) Option {
+	return func(o *options) error {
+		if bytes <= 0 {
+			return fmt.Errorf("max recv message size must be positive: %d", bytes)
+		}
+		o.maxRecvMsgSize = bytes
+		return nil
+	}
+}
+
+// WithStreamInterceptors appends additional grpc.StreamServerInterceptors to the gRPC server's
+// interceptor chain, after its standard otelgrpc/logging/metrics interceptors. Use this to wire
+// in StreamItemsInterceptor or IdempotentSessionStreamInterceptor for streaming RPCs such as
+// BillingService.StreamUpdateInvoices - there is no other way to reach a server's stream chain
+// from outside the package.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) error {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithGRPCGateway mounts a grpc-gateway handler (e.g. v1.RegisterBillingServiceHandler) onto the
+// server's HTTP mux, reaching the services registered on Server.GRPC() over an in-process
+// connection. ListenAndServe runs register once the gRPC listener is bound and before the HTTP
+// server starts serving, which is the only point this can be wired up without a race.
+func WithGRPCGateway(register GatewayRegistration) Option {
+	return func(o *options) error {
+		if register == nil {
+			return fmt.Errorf("gateway registration must not be nil")
+		}
+		o.gatewayRegistrations = append(o.gatewayRegistrations, register)
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ReattachServersEnvVar is the environment variable a server checks on startup for reattach
+// configuration. When set, ListenAndServe skips net.Listen for any service named here and instead
+// calls net.FileListener on the inherited file descriptor, so a parent process that already holds
+// the listening socket (e.g. a test driver spawning this binary under dlv with the listener passed
+// through exec.Cmd.ExtraFiles) can reattach the child to it deterministically, without racing the
+// server for an OS-assigned ephemeral port or fighting it for the same address.
+//
+// The value is a JSON object mapping service name ("debug", "http" or "grpc") to ReattachConfig,
+// mirroring the provider reattach mode Terraform uses for its plugin protocol.
+const ReattachServersEnvVar = "GITPOD_REATTACH_SERVERS"
+
+// ReattachConfig describes a socket a server process should reattach to instead of binding fresh.
+// FD is the file descriptor number the listening socket is available as in the child process (as
+// set up by the parent via exec.Cmd.ExtraFiles - fd 3 for the first extra file, 4 for the second,
+// and so on). Addr and Network describe the same socket; NewReattachClient dials Addr and does
+// not read Network.
+type ReattachConfig struct {
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+	FD      int    `json:"fd"`
+}
+
+// ReattachConfigs maps service name ("debug", "http", "grpc") to its ReattachConfig.
+type ReattachConfigs map[string]ReattachConfig
+
+// Reattach serializes cfgs into the value that must be set on ReattachServersEnvVar for a child
+// process to reattach its services to the given addresses.
+func Reattach(cfgs ReattachConfigs) (string, error) {
+	b, err := json.Marshal(cfgs)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal reattach config: %w", err)
+	}
+	return string(b), nil
+}
+
+// reattachConfigsFromEnv reads and parses ReattachServersEnvVar, if set. It returns a nil map
+// when the variable is unset, so the server falls back to its normal, freshly-bound listeners.
+func reattachConfigsFromEnv() (ReattachConfigs, error) {
+	raw := os.Getenv(ReattachServersEnvVar)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var cfgs ReattachConfigs
+	if err := json.Unmarshal([]byte(raw), &cfgs); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", ReattachServersEnvVar, err)
+	}
+	return cfgs, nil
+}
+
+// listen returns the net.Listener ListenAndServe should serve service on: one reattached to the
+// inherited fd advertised by reattach, if configured for service, or a freshly bound one on
+// cfg.Address otherwise.
+func listen(service string, cfg *ServerConfiguration, reattach ReattachConfigs) (net.Listener, error) {
+	rc, ok := reattach[service]
+	if !ok {
+		return net.Listen("tcp", cfg.Address)
+	}
+
+	f := os.NewFile(uintptr(rc.FD), fmt.Sprintf("reattach-%s", service))
+	if f == nil {
+		return nil, fmt.Errorf("reattach config for %q has no usable fd (%d)", service, rc.FD)
+	}
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reattach to inherited fd %d for %q: %w", rc.FD, service, err)
+	}
+	// net.FileListener dup()s f, so we must close our copy to avoid leaking it.
+	f.Close()
+
+	return l, nil
+}
+
+// NewReattachClient dials the gRPC service named by service (as produced by a server running in
+// reattach mode) using the config advertised on ReattachServersEnvVar. It is intended for tests
+// that spawn a real server binary and want to connect to it without knowing its address ahead of
+// time. creds are the transport credentials to dial with - pass insecure.NewCredentials() for a
+// plaintext server, or credentials matching the server's TLSConfiguration otherwise.
+func NewReattachClient(ctx context.Context, service string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	cfgs, err := reattachConfigsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, ok := cfgs[service]
+	if !ok {
+		return nil, fmt.Errorf("no reattach config for service %q in %s", service, ReattachServersEnvVar)
+	}
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+	}, opts...)
+
+	return grpc.DialContext(ctx, cfg.Addr, dialOpts...)
+}
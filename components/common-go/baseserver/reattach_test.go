@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestListen_ReattachesToInheritedFD(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to open a listener to reattach to: %v", err)
+	}
+	defer orig.Close()
+
+	f, err := orig.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get the listener's fd: %v", err)
+	}
+	defer f.Close()
+
+	reattach := ReattachConfigs{
+		"grpc": {Network: "tcp", Addr: orig.Addr().String(), FD: int(f.Fd())},
+	}
+
+	l, err := listen("grpc", &ServerConfiguration{Address: "127.0.0.1:0"}, reattach)
+	if err != nil {
+		t.Fatalf("listen failed to reattach: %v", err)
+	}
+	defer l.Close()
+
+	if l.Addr().String() != orig.Addr().String() {
+		t.Fatalf("reattached listener address = %q, want the inherited socket's address %q", l.Addr(), orig.Addr())
+	}
+}
+
+func TestListen_BindsFreshWhenNotConfiguredToReattach(t *testing.T) {
+	l, err := listen("grpc", &ServerConfiguration{Address: "127.0.0.1:0"}, nil)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+
+	if l.Addr().(*net.TCPAddr).Port == 0 {
+		t.Fatal("expected listen to bind a real port when reattach isn't configured for this service")
+	}
+}
+
+func TestListen_ErrorsOnUnusableFD(t *testing.T) {
+	reattach := ReattachConfigs{"grpc": {FD: 999999}}
+
+	if _, err := listen("grpc", &ServerConfiguration{Address: "127.0.0.1:0"}, reattach); err == nil {
+		t.Fatal("expected an error reattaching to a file descriptor that was never opened")
+	}
+}
+
+func TestNewReattachClient_ErrorsWhenServiceNotAdvertised(t *testing.T) {
+	t.Setenv(ReattachServersEnvVar, `{"grpc":{"network":"tcp","addr":"127.0.0.1:1","fd":3}}`)
+
+	if _, err := NewReattachClient(context.Background(), "http", insecure.NewCredentials()); err == nil {
+		t.Fatal("expected an error requesting a reattach client for a service not in GITPOD_REATTACH_SERVERS")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	common_grpc "github.com/gitpod-io/gitpod/common-go/grpc"
 	"github.com/gitpod-io/gitpod/common-go/log"
@@ -22,9 +23,22 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// grpcMaxConnectionAge bounds how long a client may keep a single gRPC connection open to
+	// this server before it's asked to reconnect, so clients rotate off stale backends.
+	grpcMaxConnectionAge = 30 * time.Minute
+	// grpcMaxConnectionAgeGrace is the time a connection is given to finish in-flight RPCs
+	// after grpcMaxConnectionAge before it's forcibly closed.
+	grpcMaxConnectionAgeGrace = 5 * time.Minute
 )
 
 func New(name string, opts ...Option) (*Server, error) {
@@ -38,13 +52,23 @@ func New(name string, opts ...Option) (*Server, error) {
 		options: options,
 	}
 
+	server.reattach, err = reattachConfigsFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ReattachServersEnvVar, err)
+	}
+
+	err = server.initializeTracing()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+
 	err = server.initializeDebug()
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize debug server: %w", err)
 	}
 
 	server.httpMux = http.NewServeMux()
-	server.http = &http.Server{Handler: server.httpMux}
+	server.http = &http.Server{Handler: otelhttp.NewHandler(server.httpMux, name, otelhttp.WithTracerProvider(server.tracerProvider))}
 
 	err = server.initializeGRPC()
 	if err != nil {
@@ -70,6 +94,7 @@ func New(name string, opts ...Option) (*Server, error) {
 //		- Currently does not come with any standard HTTP middlewares
 //		- Started when baseserver is configured WithHTTPPort (port is non-negative)
 // 		- Use Server.HTTPMux() to get access to the root handler and register your endpoints
+//		- Use WithGRPCGateway to additionally mount a grpc-gateway handler for the gRPC services
 type Server struct {
 	// Name is the name of this server, used for logging context
 	Name string
@@ -92,6 +117,19 @@ type Server struct {
 	// listening indicates the server is serving. When closed, the server is in the process of graceful termination.
 	listening chan struct{}
 	closeOnce sync.Once
+
+	// reattach holds the reattach configuration read from ReattachServersEnvVar, if any. When
+	// set for a given service, ListenAndServe reattaches to the inherited fd it advertises
+	// instead of calling net.Listen on the address in ServerConfiguration.
+	reattach ReattachConfigs
+
+	// tracerProvider is the OpenTelemetry TracerProvider backing Tracer(). It is a no-op
+	// provider unless tracing was enabled via WithTracing.
+	tracerProvider trace.TracerProvider
+	// tracerShutdown flushes and stops the batch span processor backing tracerProvider. It is
+	// nil unless tracing was enabled via WithTracing, in which case close calls it so buffered
+	// spans aren't dropped and its export goroutine doesn't leak past graceful shutdown.
+	tracerShutdown func(context.Context) error
 }
 
 func serveHTTP(cfg *ServerConfiguration, srv *http.Server, l net.Listener) (err error) {
@@ -115,7 +153,7 @@ func (s *Server) ListenAndServe() error {
 	}()
 
 	if srv := s.options.config.Services.Debug; srv != nil {
-		s.debugListener, err = net.Listen("tcp", srv.Address)
+		s.debugListener, err = listen("debug", srv, s.reattach)
 		if err != nil {
 			return fmt.Errorf("failed to start debug server: %w", err)
 		}
@@ -130,32 +168,39 @@ func (s *Server) ListenAndServe() error {
 		}()
 	}
 
-	if srv := s.options.config.Services.HTTP; srv != nil {
-		s.httpListener, err = net.Listen("tcp", srv.Address)
+	if srv := s.options.config.Services.GRPC; srv != nil {
+		s.grpcListener, err = listen("grpc", srv, s.reattach)
 		if err != nil {
-			return fmt.Errorf("failed to start HTTP server: %w", err)
+			return fmt.Errorf("failed to start gRPC server: %w", err)
 		}
-		s.http.Addr = srv.Address
 
 		go func() {
-			err := serveHTTP(srv, s.http, s.httpListener)
+			err := s.grpc.Serve(s.grpcListener)
 			if err != nil {
-				s.Logger().WithError(err).Errorf("HTTP server encountered an error - closing remaining servers.")
+				s.Logger().WithError(err).Errorf("gRPC server encountered an error - closing remaining servers.")
 				s.Close()
 			}
 		}()
 	}
 
-	if srv := s.options.config.Services.GRPC; srv != nil {
-		s.grpcListener, err = net.Listen("tcp", srv.Address)
+	// Gateway handlers must be mounted on httpMux after the gRPC listener is bound (they dial it
+	// in-process) but before the HTTP server below starts serving, so the routes are already in
+	// place for the first HTTP request.
+	if err := s.mountGateways(context.Background()); err != nil {
+		return fmt.Errorf("failed to mount gRPC gateway: %w", err)
+	}
+
+	if srv := s.options.config.Services.HTTP; srv != nil {
+		s.httpListener, err = listen("http", srv, s.reattach)
 		if err != nil {
-			return fmt.Errorf("failed to start gRPC server: %w", err)
+			return fmt.Errorf("failed to start HTTP server: %w", err)
 		}
+		s.http.Addr = srv.Address
 
 		go func() {
-			err := s.grpc.Serve(s.grpcListener)
+			err := serveHTTP(srv, s.http, s.httpListener)
 			if err != nil {
-				s.Logger().WithError(err).Errorf("gRPC server encountered an error - closing remaining servers.")
+				s.Logger().WithError(err).Errorf("HTTP server encountered an error - closing remaining servers.")
 				s.Close()
 			}
 		}()
@@ -240,6 +285,13 @@ func (s *Server) close(ctx context.Context) error {
 		s.Logger().Info("Debug server terminated.")
 	}
 
+	if s.tracerShutdown != nil {
+		if err := s.tracerShutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shut down tracer provider: %w", err)
+		}
+		s.Logger().Info("Tracer provider terminated.")
+	}
+
 	return nil
 }
 
@@ -272,6 +324,9 @@ func (s *Server) initializeDebug() error {
 	mux.Handle(pprof.Path, pprof.Handler())
 	logger.Debug("Serving profiler on /debug/pprof")
 
+	s.registerDebugTracing(mux)
+	logger.Debugf("Serving tracing config on %s", debugTracingPath)
+
 	s.debug = &http.Server{
 		Handler: mux,
 	}
@@ -289,13 +344,19 @@ func (s *Server) initializeGRPC() error {
 	}
 
 	unary := []grpc.UnaryServerInterceptor{
+		otelgrpc.UnaryServerInterceptor(otelgrpc.WithTracerProvider(s.tracerProvider)),
 		grpc_logrus.UnaryServerInterceptor(s.Logger()),
 		grpcMetrics.UnaryServerInterceptor(),
 	}
+	if s.options.idempotencyStore != nil {
+		unary = append(unary, IdempotencyUnaryServerInterceptor(s.options.idempotencyStore, s.options.idempotencyTTL))
+	}
 	stream := []grpc.StreamServerInterceptor{
+		otelgrpc.StreamServerInterceptor(otelgrpc.WithTracerProvider(s.tracerProvider)),
 		grpc_logrus.StreamServerInterceptor(s.Logger()),
 		grpcMetrics.StreamServerInterceptor(),
 	}
+	stream = append(stream, s.options.streamInterceptors...)
 
 	opts := common_grpc.ServerOptionsWithInterceptors(stream, unary)
 	if cfg := s.options.config.Services.GRPC; cfg != nil && cfg.TLS != nil {
@@ -311,6 +372,18 @@ func (s *Server) initializeGRPC() error {
 		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
+	// Rotate clients off this server periodically, so they don't pile up on a backend that's
+	// about to be replaced (e.g. during a rolling deploy) and so idle connections that survived
+	// a load balancer's own idle timeout eventually get cycled.
+	opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+		MaxConnectionAge:      grpcMaxConnectionAge,
+		MaxConnectionAgeGrace: grpcMaxConnectionAgeGrace,
+	}))
+
+	if s.options.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(s.options.maxRecvMsgSize))
+	}
+
 	s.grpc = grpc.NewServer(opts...)
 
 	// Register health service by default
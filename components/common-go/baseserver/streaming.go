@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// StreamItemsInterceptor returns a grpc.StreamServerInterceptor that counts messages received
+// on client-streaming RPCs, for services like BillingService.StreamUpdateInvoices that batch a
+// long-running stream of messages and want to expose ingestion progress as Prometheus counters:
+// itemsTotal is incremented once per message received, batchesTotal once every batchSize
+// messages. Pass it to WithStreamInterceptors to wire it into a Server's gRPC interceptor chain.
+func StreamItemsInterceptor(itemsTotal, batchesTotal prometheus.Counter, batchSize int) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &countingServerStream{
+			ServerStream: ss,
+			itemsTotal:   itemsTotal,
+			batchesTotal: batchesTotal,
+			batchSize:    batchSize,
+		})
+	}
+}
+
+type countingServerStream struct {
+	grpc.ServerStream
+
+	itemsTotal   prometheus.Counter
+	batchesTotal prometheus.Counter
+	batchSize    int
+	received     int
+}
+
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	s.itemsTotal.Inc()
+	s.received++
+	if s.batchSize > 0 && s.received%s.batchSize == 0 {
+		s.batchesTotal.Inc()
+	}
+
+	return nil
+}
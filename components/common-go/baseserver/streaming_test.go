@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+// recvOnlyServerStream is a grpc.ServerStream whose RecvMsg succeeds count times, then returns
+// io.EOF - enough to drive countingServerStream without standing up a real gRPC connection.
+type recvOnlyServerStream struct {
+	grpc.ServerStream
+
+	ctx       context.Context
+	remaining int
+}
+
+func (s *recvOnlyServerStream) Context() context.Context { return s.ctx }
+
+func (s *recvOnlyServerStream) RecvMsg(m interface{}) error {
+	if s.remaining <= 0 {
+		return io.EOF
+	}
+	s.remaining--
+	return nil
+}
+
+func TestStreamItemsInterceptor_CountsItemsAndBatches(t *testing.T) {
+	itemsTotal := prometheus.NewCounter(prometheus.CounterOpts{Name: "items_total"})
+	batchesTotal := prometheus.NewCounter(prometheus.CounterOpts{Name: "batches_total"})
+	interceptor := StreamItemsInterceptor(itemsTotal, batchesTotal, 2)
+
+	const messageCount = 5
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(new(struct{})); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	stream := &recvOnlyServerStream{ctx: context.Background(), remaining: messageCount}
+	if err := interceptor(nil, stream, &grpc.StreamServerInfo{}, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(itemsTotal); got != messageCount {
+		t.Fatalf("items_total = %v, want %v", got, messageCount)
+	}
+	// batchSize is 2, so 5 messages complete 2 whole batches.
+	if got := testutil.ToFloat64(batchesTotal); got != 2 {
+		t.Fatalf("batches_total = %v, want 2", got)
+	}
+}
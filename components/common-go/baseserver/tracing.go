@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// debugTracingPath is where the debug server exposes the current sampler configuration.
+const debugTracingPath = "/debug/tracing"
+
+// initializeTracing builds a TracerProvider from the configured exporter and sampler, if tracing
+// was enabled via WithTracing. When tracing is disabled, s.Tracer returns a no-op tracer.
+func (s *Server) initializeTracing() error {
+	if s.options.tracingExporter == nil {
+		s.tracerProvider = trace.NewNoopTracerProvider()
+		return nil
+	}
+
+	sampler := s.options.traceSampler
+	if sampler == nil {
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceNameKey.String(s.Name)))
+	if err != nil {
+		return fmt.Errorf("failed to build tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(s.options.tracingExporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(res),
+	)
+	s.tracerProvider = provider
+	s.tracerShutdown = provider.Shutdown
+
+	return nil
+}
+
+// Tracer returns a trace.Tracer scoped to name (e.g. "billing"), backed by the TracerProvider
+// registered on this Server. When tracing is disabled, it returns a no-op tracer, so handlers
+// can call it unconditionally instead of checking for a global tracer being configured.
+func (s *Server) Tracer(name string) trace.Tracer {
+	return s.tracerProvider.Tracer(name)
+}
+
+// TracerProvider returns the trace.TracerProvider backing this server, for passing to
+// instrumentation (otelgrpc, otelhttp) that needs one explicitly rather than relying on the
+// global otel.GetTracerProvider().
+func (s *Server) TracerProvider() trace.TracerProvider {
+	return s.tracerProvider
+}
+
+// registerDebugTracing exposes the current sampler configuration on debugTracingPath, for
+// operators checking why a given request was (or wasn't) sampled.
+func (s *Server) registerDebugTracing(mux *http.ServeMux) {
+	mux.HandleFunc(debugTracingPath, func(w http.ResponseWriter, r *http.Request) {
+		enabled := s.options.tracingExporter != nil
+		sampler := "none"
+		if s.options.traceSampler != nil {
+			sampler = s.options.traceSampler.Description()
+		} else if enabled {
+			sampler = sdktrace.ParentBased(sdktrace.AlwaysSample()).Description()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Enabled bool   `json:"enabled"`
+			Sampler string `json:"sampler"`
+		}{Enabled: enabled, Sampler: sampler})
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package baseserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// noopSpanExporter is a sdktrace.SpanExporter that drops every span, standing in for a real OTLP
+// exporter in tests that only care that initializeTracing wires a TracerProvider up correctly.
+type noopSpanExporter struct{}
+
+func (noopSpanExporter) ExportSpans(context.Context, []sdktrace.ReadOnlySpan) error { return nil }
+func (noopSpanExporter) Shutdown(context.Context) error                             { return nil }
+
+func TestInitializeTracing_NoopWhenTracingDisabled(t *testing.T) {
+	s := &Server{Name: "test", options: &options{}}
+
+	if err := s.initializeTracing(); err != nil {
+		t.Fatalf("initializeTracing() error: %v", err)
+	}
+
+	if s.tracerProvider == nil {
+		t.Fatal("tracerProvider is nil, want a no-op TracerProvider")
+	}
+	if s.tracerShutdown != nil {
+		t.Fatal("tracerShutdown is set despite tracing being disabled; close() would call it needlessly")
+	}
+}
+
+func TestInitializeTracing_SetsShutdownWhenTracingEnabled(t *testing.T) {
+	s := &Server{Name: "test", options: &options{tracingExporter: noopSpanExporter{}}}
+
+	if err := s.initializeTracing(); err != nil {
+		t.Fatalf("initializeTracing() error: %v", err)
+	}
+
+	if s.tracerProvider == nil {
+		t.Fatal("tracerProvider is nil, want a real TracerProvider backed by the configured exporter")
+	}
+	if s.tracerShutdown == nil {
+		t.Fatal("tracerShutdown is nil; the batch span processor behind tracerProvider would never be flushed or stopped")
+	}
+	if err := s.tracerShutdown(context.Background()); err != nil {
+		t.Fatalf("tracerShutdown() error: %v", err)
+	}
+}
+
+func TestRegisterDebugTracing_ReportsDisabledWithoutAnExporter(t *testing.T) {
+	s := &Server{Name: "test", options: &options{}}
+	mux := http.NewServeMux()
+	s.registerDebugTracing(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, debugTracingPath, nil))
+
+	var got struct {
+		Enabled bool   `json:"enabled"`
+		Sampler string `json:"sampler"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if got.Enabled {
+		t.Fatal("enabled = true, want false when no tracing exporter is configured")
+	}
+	if got.Sampler != "none" {
+		t.Fatalf("sampler = %q, want %q", got.Sampler, "none")
+	}
+}
+
+func TestRegisterDebugTracing_ReportsConfiguredSampler(t *testing.T) {
+	sampler := sdktrace.AlwaysSample()
+	s := &Server{Name: "test", options: &options{tracingExporter: noopSpanExporter{}, traceSampler: sampler}}
+	mux := http.NewServeMux()
+	s.registerDebugTracing(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, debugTracingPath, nil))
+
+	var got struct {
+		Enabled bool   `json:"enabled"`
+		Sampler string `json:"sampler"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if !got.Enabled {
+		t.Fatal("enabled = false, want true when a tracing exporter is configured")
+	}
+	if got.Sampler != sampler.Description() {
+		t.Fatalf("sampler = %q, want %q", got.Sampler, sampler.Description())
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
+)
+
+// defaultClientKeepaliveParams are applied by DialWithReconnect so that a client notices a dead
+// or idle-dropped TCP connection instead of silently hanging onto it.
+var defaultClientKeepaliveParams = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// idleReconnectThreshold is how long a connection may sit in connectivity.Idle or
+// connectivity.TransientFailure before DialWithReconnect forces it to reconnect.
+const idleReconnectThreshold = 30 * time.Second
+
+// registerForcedReconnectsTotal registers the forced_reconnects_total counter against reg,
+// returning the already-registered instance if another DialWithReconnect call on the same
+// registry (e.g. another connection from the same component) registered it first, so all of a
+// component's DialWithReconnect calls share one counter on its own /metrics endpoint rather than
+// the process-global prometheus.DefaultRegisterer, which baseserver-based components never serve.
+func registerForcedReconnectsTotal(reg prometheus.Registerer) prometheus.Counter {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "gitpod",
+		Subsystem: "grpc_client",
+		Name:      "forced_reconnects_total",
+		Help:      "Number of times DialWithReconnect forced a connection out of an idle or transient-failure state.",
+	})
+
+	if err := reg.Register(counter); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector.(prometheus.Counter)
+		}
+		logrus.WithError(err).Warn("failed to register forced_reconnects_total metric")
+	}
+
+	return counter
+}
+
+// DialWithReconnect wraps grpc.DialContext with client-side keepalive defaults, otelgrpc tracing
+// interceptors, and a background watcher that forces a reconnect whenever the connection sits idle
+// or in transient failure for too long. It's meant for long-lived connections between Gitpod
+// components (e.g. usage -> server, ws-manager -> registry) that otherwise silently break when the
+// upstream restarts or a load balancer drops the idle TCP connection. The otelgrpc interceptors
+// inject the calling span's traceparent into outgoing request metadata, so a baseserver-based
+// callee's otelgrpc.UnaryServerInterceptor/StreamServerInterceptor picks it up and its spans show
+// up as children of the caller's instead of starting new, disconnected traces. tp is passed
+// explicitly to otelgrpc (via otelgrpc.WithTracerProvider) rather than left to fall back to the
+// process-global otel.GetTracerProvider(): baseserver deliberately never installs its real
+// TracerProvider globally (see Server.TracerProvider's doc comment), so without this the
+// interceptors would use the global no-op provider, whose spans carry an empty SpanContext and
+// propagate no traceparent at all - pass the calling component's Server.TracerProvider(). reg is
+// the registry forced_reconnects_total is registered against - pass the calling component's
+// Server.MetricsRegistry() so the counter shows up on that component's own /metrics endpoint.
+func DialWithReconnect(ctx context.Context, target string, tp trace.TracerProvider, reg prometheus.Registerer, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithKeepaliveParams(defaultClientKeepaliveParams),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor(otelgrpc.WithTracerProvider(tp))),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor(otelgrpc.WithTracerProvider(tp))),
+	}, opts...)
+
+	conn, err := grpc.DialContext(ctx, target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	go watchIdleConnection(ctx, target, conn, registerForcedReconnectsTotal(reg), idleReconnectThreshold)
+
+	return conn, nil
+}
+
+// watchIdleConnection observes conn's connectivity state and calls Connect() to force
+// re-establishment whenever it sits in Idle or TransientFailure beyond threshold. It returns once
+// ctx is done or conn reaches connectivity.Shutdown (the terminal state conn.Close() puts it in) -
+// otherwise, since DialWithReconnect is typically called with context.Background() for long-lived
+// connections, this goroutine would leak for the rest of the process once the caller closes conn,
+// because WaitForStateChange(ctx, Shutdown) only unblocks on ctx from then on. threshold is a
+// parameter rather than always idleReconnectThreshold so tests can drive the state machine without
+// waiting out the real threshold.
+func watchIdleConnection(ctx context.Context, target string, conn *grpc.ClientConn, forcedReconnectsTotal prometheus.Counter, threshold time.Duration) {
+	for {
+		state := conn.GetState()
+		if state == connectivity.Shutdown {
+			return
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return
+		}
+
+		state = conn.GetState()
+		if state == connectivity.Shutdown {
+			return
+		}
+		if state != connectivity.Idle && state != connectivity.TransientFailure {
+			continue
+		}
+
+		select {
+		case <-time.After(threshold):
+		case <-ctx.Done():
+			return
+		}
+
+		if s := conn.GetState(); s == connectivity.Idle || s == connectivity.TransientFailure {
+			logrus.WithField("target", target).WithField("state", s.String()).Info("gRPC connection stuck idle or failing, forcing reconnect")
+			forcedReconnectsTotal.Inc()
+			conn.Connect()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2023 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// dialIdle opens a real *grpc.ClientConn to a loopback address nothing is listening on. With
+// grpc.WithBlock omitted the dial returns at once; the conn never becomes Ready, and each
+// connection attempt fails into connectivity.TransientFailure without ever completing a real
+// RPC - enough to drive watchIdleConnection without a live server.
+func dialIdle(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+
+	conn, err := grpc.DialContext(context.Background(), "127.0.0.1:0", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to create client conn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWatchIdleConnection_ForcesReconnectAfterThreshold(t *testing.T) {
+	conn := dialIdle(t)
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "forced_reconnects_total"})
+
+	// Drive the connection out of Idle so watchIdleConnection sees a state it should act on,
+	// then give it a threshold short enough for the test to observe without sleeping 30s.
+	conn.Connect()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchIdleConnection(context.Background(), "test-target", conn, counter, time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(counter) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(counter); got == 0 {
+		t.Fatal("forced_reconnects_total was never incremented; want watchIdleConnection to force a reconnect once the connection sits idle/failing past threshold")
+	}
+
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchIdleConnection did not return after conn.Close(); it would leak for the rest of the process")
+	}
+}
+
+func TestWatchIdleConnection_ReturnsWhenContextIsDone(t *testing.T) {
+	conn := dialIdle(t)
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "forced_reconnects_total"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchIdleConnection(ctx, "test-target", conn, counter, time.Hour)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchIdleConnection did not return after ctx was cancelled")
+	}
+}
+
+func TestWatchIdleConnection_ReturnsImmediatelyOnAlreadyShutdownConn(t *testing.T) {
+	conn := dialIdle(t)
+	conn.Close()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "forced_reconnects_total"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchIdleConnection(context.Background(), "test-target", conn, counter, time.Hour)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchIdleConnection did not return immediately for a conn already in connectivity.Shutdown")
+	}
+	if conn.GetState() != connectivity.Shutdown {
+		t.Fatalf("conn.GetState() = %v, want Shutdown (test setup invariant)", conn.GetState())
+	}
+}
+
+func TestRegisterForcedReconnectsTotal_SharesCounterAcrossCallsOnSameRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	first := registerForcedReconnectsTotal(reg)
+	second := registerForcedReconnectsTotal(reg)
+
+	first.Inc()
+	if got := testutil.ToFloat64(second); got != 1 {
+		t.Fatalf("second registerForcedReconnectsTotal() returned a different counter instance; got %v, want 1 (both calls should share one counter)", got)
+	}
+}